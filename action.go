@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Action selects what to do with each confirmed duplicate.
+type Action string
+
+const (
+	ActionPrint    Action = "print"
+	ActionSymlink  Action = "symlink"
+	ActionHardlink Action = "hardlink"
+	ActionDelete   Action = "delete"
+)
+
+// ReplacementLog is a single machine-readable record of a replacement (or
+// attempted replacement) performed by -action symlink/hardlink/delete, so
+// the operation can be audited or reversed later.
+type ReplacementLog struct {
+	Action    Action `json:"action"`
+	Canonical string `json:"canonical"`
+	Path      string `json:"path"`
+	Error     string `json:"error,omitempty"`
+}
+
+// applyAction walks every duplicate set in store and, unless action is
+// ActionPrint, replaces each non-canonical member according to action.
+func applyAction(action Action, store *PathStore, basedir, dupdir string, chmod int, fsync bool, out io.Writer) {
+	for _, files := range store.paths {
+		applySet(action, files, basedir, dupdir, chmod, fsync, out)
+	}
+}
+
+func applySet(action Action, files []File, basedir, dupdir string, chmod int, fsync bool, out io.Writer) {
+	files = expandAliases(files)
+	if len(files) < 2 {
+		return
+	}
+
+	canonical, err := chooseCanonical(files, basedir)
+	if err != nil {
+		errOut(err)
+	}
+
+	if action == ActionPrint {
+		fmt.Fprintln(out, canonical.path)
+		for _, f := range files {
+			if f.path != canonical.path {
+				fmt.Fprintln(out, "  "+f.path)
+			}
+		}
+		return
+	}
+
+	for _, f := range files {
+		if f.path == canonical.path {
+			continue
+		}
+		if dupdir != "" && !underDir(f.path, dupdir) {
+			continue
+		}
+		entry := ReplacementLog{Action: action, Canonical: canonical.path, Path: f.path}
+		if err := replace(action, canonical.path, f.path, chmod, fsync); err != nil {
+			entry.Error = err.Error()
+		}
+		emitLog(out, entry)
+	}
+}
+
+// expandAliases turns each alias path recorded under -hardlinks=group
+// (already sharing a (dev, inode) with its representative File) into its
+// own File entry carrying the representative's hashes, so an already-
+// hardlinked path is printed and acted on like any other duplicate
+// instead of being silently dropped.
+func expandAliases(files []File) []File {
+	expanded := files
+	for _, f := range files {
+		for _, alias := range f.aliases {
+			expanded = append(expanded, File{
+				path:     alias,
+				size:     f.size,
+				dev:      f.dev,
+				ino:      f.ino,
+				headHash: f.headHash,
+				fullHash: f.fullHash,
+			})
+		}
+	}
+	return expanded
+}
+
+// chooseCanonical picks the file within a duplicate set that every other
+// member should be replaced with: prefer membership in basedir, then the
+// oldest mtime, then the lexicographically first path.
+func chooseCanonical(files []File, basedir string) (File, error) {
+	if len(files) == 0 {
+		return File{}, errors.New("empty duplicate set")
+	}
+	candidates := files
+	if basedir != "" {
+		var inBase []File
+		for _, f := range files {
+			if underDir(f.path, basedir) {
+				inBase = append(inBase, f)
+			}
+		}
+		if len(inBase) > 0 {
+			candidates = inBase
+		}
+	}
+
+	best := candidates[0]
+	bestMtime, bestErr := mtime(best.path)
+	for _, f := range candidates[1:] {
+		m, err := mtime(f.path)
+		switch {
+		case bestErr == nil && err == nil && !m.Equal(bestMtime):
+			if m.Before(bestMtime) {
+				best, bestMtime, bestErr = f, m, err
+			}
+		case bestErr != nil && err == nil:
+			// best's mtime is unknown; any candidate with a valid mtime
+			// outranks it regardless of path.
+			best, bestMtime, bestErr = f, m, err
+		case bestErr == nil && err != nil:
+			// f's mtime is unknown; best already has a valid one.
+		case f.path < best.path:
+			best, bestMtime, bestErr = f, m, err
+		}
+	}
+	return best, nil
+}
+
+func mtime(path string) (time.Time, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+func underDir(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && !strings.HasPrefix(rel, "..")
+}
+
+// replace swaps dupPath for a link to (or removal in favor of)
+// canonicalPath. The new entry is built under a temp name in dupPath's
+// own directory, so a hardlink lands on the same filesystem, then
+// os.Rename swaps it into place atomically.
+func replace(action Action, canonicalPath, dupPath string, chmod int, fsync bool) error {
+	dir := filepath.Dir(dupPath)
+
+	if action == ActionDelete {
+		if err := os.Remove(dupPath); err != nil {
+			return err
+		}
+		return syncDir(dir, fsync)
+	}
+
+	tmp := filepath.Join(dir, fmt.Sprintf(".godupes-tmp-%d-%s", os.Getpid(), filepath.Base(dupPath)))
+
+	switch action {
+	case ActionSymlink:
+		if err := os.Symlink(canonicalPath, tmp); err != nil {
+			return err
+		}
+	case ActionHardlink:
+		if err := checkSameDevice(canonicalPath, dir); err != nil {
+			return err
+		}
+		if err := os.Link(canonicalPath, tmp); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown action %q", action)
+	}
+
+	if chmod != 0 {
+		if err := os.Chmod(tmp, os.FileMode(chmod)); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	}
+
+	if err := os.Rename(tmp, dupPath); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return syncDir(dir, fsync)
+}
+
+func syncDir(dir string, fsync bool) error {
+	if !fsync {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func emitLog(w io.Writer, entry ReplacementLog) {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	fmt.Fprintln(w, string(b))
+}
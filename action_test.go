@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("WriteFile(%s): %v", path, err)
+	}
+}
+
+func setMtime(t *testing.T, path string, when time.Time) {
+	t.Helper()
+	if err := os.Chtimes(path, when, when); err != nil {
+		t.Fatalf("Chtimes(%s): %v", path, err)
+	}
+}
+
+func TestChooseCanonical(t *testing.T) {
+	dir := t.TempDir()
+	older := filepath.Join(dir, "a-older")
+	newer := filepath.Join(dir, "b-newer")
+	writeFile(t, older, "x")
+	writeFile(t, newer, "x")
+	now := time.Now()
+	setMtime(t, older, now.Add(-time.Hour))
+	setMtime(t, newer, now)
+
+	basedirPath := filepath.Join(dir, "base", "c-in-base")
+	if err := os.MkdirAll(filepath.Dir(basedirPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, basedirPath, "x")
+	setMtime(t, basedirPath, now.Add(time.Hour)) // newest, but still preferred via basedir
+
+	missing := filepath.Join(dir, "z-missing")
+
+	tests := []struct {
+		name    string
+		files   []File
+		basedir string
+		want    string
+	}{
+		{
+			name:  "older mtime wins",
+			files: []File{{path: newer}, {path: older}},
+			want:  older,
+		},
+		{
+			name:  "lexicographic fallback when mtimes tie",
+			files: []File{{path: filepath.Join(dir, "tie-b")}, {path: filepath.Join(dir, "tie-a")}},
+			want:  filepath.Join(dir, "tie-a"),
+		},
+		{
+			name:    "basedir membership preferred over mtime",
+			files:   []File{{path: newer}, {path: older}, {path: basedirPath}},
+			basedir: filepath.Join(dir, "base"),
+			want:    basedirPath,
+		},
+		{
+			name:  "valid stat outranks a failed stat regardless of path order",
+			files: []File{{path: missing}, {path: newer}},
+			want:  newer,
+		},
+		{
+			name:  "valid stat outranks a failed stat even when failed path sorts first",
+			files: []File{{path: older}, {path: missing}},
+			want:  older,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// tie-a/tie-b share the same (zero) mtime since they're never created.
+			got, err := chooseCanonical(tc.files, tc.basedir)
+			if err != nil {
+				t.Fatalf("chooseCanonical: %v", err)
+			}
+			if got.path != tc.want {
+				t.Errorf("chooseCanonical() = %q, want %q", got.path, tc.want)
+			}
+		})
+	}
+}
+
+func TestChooseCanonicalEmptySet(t *testing.T) {
+	if _, err := chooseCanonical(nil, ""); err == nil {
+		t.Fatal("chooseCanonical(nil) should error on an empty set")
+	}
+}
+
+func TestReplaceSymlink(t *testing.T) {
+	dir := t.TempDir()
+	canonical := filepath.Join(dir, "canonical")
+	dup := filepath.Join(dir, "dup")
+	writeFile(t, canonical, "payload")
+	writeFile(t, dup, "payload")
+
+	if err := replace(ActionSymlink, canonical, dup, 0, false); err != nil {
+		t.Fatalf("replace: %v", err)
+	}
+
+	target, err := os.Readlink(dup)
+	if err != nil {
+		t.Fatalf("Readlink(%s): %v", dup, err)
+	}
+	if target != canonical {
+		t.Errorf("symlink target = %q, want %q", target, canonical)
+	}
+}
+
+func TestReplaceHardlink(t *testing.T) {
+	dir := t.TempDir()
+	canonical := filepath.Join(dir, "canonical")
+	dup := filepath.Join(dir, "dup")
+	writeFile(t, canonical, "payload")
+	writeFile(t, dup, "payload")
+
+	if err := replace(ActionHardlink, canonical, dup, 0, false); err != nil {
+		t.Fatalf("replace: %v", err)
+	}
+
+	canonInfo, err := os.Stat(canonical)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dupInfo, err := os.Stat(dup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(canonInfo, dupInfo) {
+		t.Errorf("dup is not hardlinked to canonical after replace")
+	}
+}
+
+func TestReplaceDelete(t *testing.T) {
+	dir := t.TempDir()
+	canonical := filepath.Join(dir, "canonical")
+	dup := filepath.Join(dir, "dup")
+	writeFile(t, canonical, "payload")
+	writeFile(t, dup, "payload")
+
+	if err := replace(ActionDelete, canonical, dup, 0, false); err != nil {
+		t.Fatalf("replace: %v", err)
+	}
+
+	if _, err := os.Stat(dup); !os.IsNotExist(err) {
+		t.Errorf("dup still exists after -action delete: %v", err)
+	}
+	if _, err := os.Stat(canonical); err != nil {
+		t.Errorf("canonical was removed, want it untouched: %v", err)
+	}
+}
+
+func TestReplaceChmod(t *testing.T) {
+	dir := t.TempDir()
+	canonical := filepath.Join(dir, "canonical")
+	dup := filepath.Join(dir, "dup")
+	writeFile(t, canonical, "payload")
+	writeFile(t, dup, "payload")
+
+	if err := replace(ActionHardlink, canonical, dup, 0600, false); err != nil {
+		t.Fatalf("replace: %v", err)
+	}
+
+	info, err := os.Lstat(dup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("dup mode = %o, want 0600", info.Mode().Perm())
+	}
+}
+
+func TestApplySetSkipsCanonical(t *testing.T) {
+	dir := t.TempDir()
+	canonical := filepath.Join(dir, "a-canonical")
+	dup := filepath.Join(dir, "b-dup")
+	writeFile(t, canonical, "payload")
+	writeFile(t, dup, "payload")
+	now := time.Now()
+	setMtime(t, canonical, now.Add(-time.Hour))
+	setMtime(t, dup, now)
+
+	var out bytes.Buffer
+	applySet(ActionHardlink, []File{{path: dup}, {path: canonical}}, "", "", 0, false, &out)
+
+	canonInfo, err := os.Stat(canonical)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dupInfo, err := os.Stat(dup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(canonInfo, dupInfo) {
+		t.Errorf("dup was not linked to canonical")
+	}
+	if out.Len() == 0 {
+		t.Errorf("applySet emitted no replacement log for the dup")
+	}
+}
+
+func TestApplySetHonorsDupdir(t *testing.T) {
+	dir := t.TempDir()
+	canonical := filepath.Join(dir, "a-canonical")
+	outside := filepath.Join(dir, "b-outside")
+	insideDupdir := filepath.Join(dir, "only", "c-inside")
+	writeFile(t, canonical, "payload")
+	writeFile(t, outside, "payload")
+	if err := os.MkdirAll(filepath.Dir(insideDupdir), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, insideDupdir, "payload")
+	now := time.Now()
+	setMtime(t, canonical, now.Add(-time.Hour))
+	setMtime(t, outside, now)
+	setMtime(t, insideDupdir, now)
+
+	var out bytes.Buffer
+	applySet(ActionDelete, []File{{path: canonical}, {path: outside}, {path: insideDupdir}}, "", filepath.Join(dir, "only"), 0, false, &out)
+
+	if _, err := os.Stat(outside); err != nil {
+		t.Errorf("outside dupdir was modified, want it untouched: %v", err)
+	}
+	if _, err := os.Stat(insideDupdir); !os.IsNotExist(err) {
+		t.Errorf("file inside dupdir was not deleted")
+	}
+}
+
+func TestApplySetSingleFileIsNoop(t *testing.T) {
+	dir := t.TempDir()
+	lone := filepath.Join(dir, "lone")
+	writeFile(t, lone, "payload")
+
+	var out bytes.Buffer
+	applySet(ActionDelete, []File{{path: lone}}, "", "", 0, false, &out)
+
+	if _, err := os.Stat(lone); err != nil {
+		t.Errorf("single-file set was acted on, want it left alone: %v", err)
+	}
+}
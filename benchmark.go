@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	benchmarkUniqueFiles   = 200
+	benchmarkDupSets       = 50
+	benchmarkCopiesPerSet  = 4
+	benchmarkFileSizeBytes = 1 << 20 // 1 MiB
+)
+
+// runBenchmark builds a synthetic tree with a known number of unique and
+// duplicate files, runs it through the pipeline, and reports each
+// stage's elapsed time and throughput so regressions show up without
+// needing a real dataset on hand.
+func runBenchmark(ctx context.Context, workers, queueSize, headSize, bufSize int, hasher Hasher) error {
+	dir, err := os.MkdirTemp("", "godupes-benchmark-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	if err := generateBenchmarkTree(dir); err != nil {
+		return err
+	}
+
+	totalFiles := int64(benchmarkUniqueFiles + benchmarkDupSets*benchmarkCopiesPerSet)
+	totalBytes := totalFiles * benchmarkFileSizeBytes
+	progress := &Progress{}
+
+	start := time.Now()
+	paths, err := dirWalk(ctx, dir)
+	if err != nil {
+		return err
+	}
+	walkElapsed := time.Since(start)
+
+	start = time.Now()
+	sizeStore, err := NewSizeStore(ctx, paths, 0, "skip", workers, queueSize, &progress.Size)
+	if err != nil {
+		return err
+	}
+	sizeElapsed := time.Since(start)
+	sizeMatched := sizeStore.Prune()
+
+	start = time.Now()
+	headStore, err := NewPathStore(ctx, sizeMatched.AllFiles(), headSize, hasher, workers, queueSize, &progress.HeadHash)
+	if err != nil {
+		return err
+	}
+	headElapsed := time.Since(start)
+	headMatched := headStore.Prune()
+
+	start = time.Now()
+	hashq := make(chan File, queueSize)
+	resultq := make(chan File, queueSize)
+	var wg sync.WaitGroup
+	go func() {
+		defer close(hashq)
+		for _, v := range headMatched.paths {
+			for _, f := range v {
+				select {
+				case hashq <- f:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go hashWorker(ctx, hashq, resultq, &wg, bufSize, hasher, &progress.FullHash)
+	}
+	go func() {
+		wg.Wait()
+		close(resultq)
+	}()
+	hashed := &PathStore{paths: make(map[string][]File), hasher: hasher}
+	for f := range resultq {
+		key := string(f.fullHash)
+		hashed.paths[key] = append(hashed.paths[key], f)
+	}
+	fullHashElapsed := time.Since(start)
+
+	// size only os.Lstats each path (no content read), so MB/s doesn't
+	// mean anything there; headhash reads headSize bytes per file, not
+	// the whole file, so it gets its own, much smaller, byte total.
+	headHashBytes := totalFiles * int64(headSize)
+	fmt.Printf("walk:     %v (%d files)\n", walkElapsed, len(paths))
+	fmt.Printf("size:     %v (%.0f files/s)\n", sizeElapsed, float64(totalFiles)/sizeElapsed.Seconds())
+	fmt.Printf("headhash: %v (%.1f MB/s)\n", headElapsed, throughputMBps(headHashBytes, headElapsed))
+	fmt.Printf("fullhash: %v (%.1f MB/s)\n", fullHashElapsed, throughputMBps(totalBytes, fullHashElapsed))
+	fmt.Println(hashed.Prune().Summarize())
+	return nil
+}
+
+func throughputMBps(totalBytes int64, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(totalBytes) / (1024 * 1024) / elapsed.Seconds()
+}
+
+// generateBenchmarkTree populates dir with benchmarkUniqueFiles files
+// that are each unique and benchmarkDupSets sets of benchmarkCopiesPerSet
+// identical files, all benchmarkFileSizeBytes in size.
+func generateBenchmarkTree(dir string) error {
+	for i := 0; i < benchmarkUniqueFiles; i++ {
+		name := filepath.Join(dir, fmt.Sprintf("unique-%d.bin", i))
+		if err := writeRandomFile(name, benchmarkFileSizeBytes); err != nil {
+			return err
+		}
+	}
+	for s := 0; s < benchmarkDupSets; s++ {
+		data := make([]byte, benchmarkFileSizeBytes)
+		if _, err := rand.Read(data); err != nil {
+			return err
+		}
+		for c := 0; c < benchmarkCopiesPerSet; c++ {
+			name := filepath.Join(dir, fmt.Sprintf("dup-%d-%d.bin", s, c))
+			if err := os.WriteFile(name, data, 0o644); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func writeRandomFile(path string, size int) error {
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
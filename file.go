@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+)
+
+// File describes a single file as it moves through the dedup pipeline.
+// size, dev and ino are filled in from the initial directory walk (via
+// os.Lstat, no open required); headHash is filled in once the file
+// survives the size-bucket pass; fullHash is filled in only for files
+// that also survive the head-hash pass. aliases holds any other paths
+// that share this file's (dev, ino) when -hardlinks=group is set.
+// headHash and fullHash are digests from the selected Hasher, so their
+// width depends on -hash.
+type File struct {
+	path     string
+	size     int64
+	dev, ino uint64
+	aliases  []string
+	headHash []byte
+	fullHash []byte
+}
+
+// computeHeadHash reads the first headSize bytes of the file and hashes
+// them, without touching the rest of the file.
+func (f *File) computeHeadHash(ctx context.Context, headSize int, hasher Hasher) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	head := make([]byte, headSize)
+	n, err := file.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	h := hasher.New()
+	h.Write(head[:n])
+	f.headHash = h.Sum(nil)
+	return nil
+}
+
+// computeHash streams the file through a bufSize buffer into an
+// incremental hasher rather than reading it into memory, so peak memory
+// for a full-file hash is O(bufSize) regardless of file size. ctx is
+// checked between chunks so a cancellation aborts a large file promptly
+// instead of reading it to completion.
+func (f *File) computeHash(ctx context.Context, bufSize int, hasher Hasher) error {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	h := hasher.New()
+	buf := make([]byte, bufSize)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n, readErr := file.Read(buf)
+		if n > 0 {
+			h.Write(buf[:n])
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+	f.fullHash = h.Sum(nil)
+	return nil
+}
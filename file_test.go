@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestComputeHeadHashOnlyReadsHeadSize(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a")
+	b := filepath.Join(dir, "b")
+	writeFile(t, a, "identical-head-but-then-diverges-aaaa")
+	writeFile(t, b, "identical-head-but-then-diverges-bbbb")
+
+	hasher := xxh3Hasher{}
+	fa := File{path: a}
+	fb := File{path: b}
+	if err := fa.computeHeadHash(context.Background(), 8, hasher); err != nil {
+		t.Fatalf("computeHeadHash(a): %v", err)
+	}
+	if err := fb.computeHeadHash(context.Background(), 8, hasher); err != nil {
+		t.Fatalf("computeHeadHash(b): %v", err)
+	}
+	if string(fa.headHash) != string(fb.headHash) {
+		t.Errorf("computeHeadHash over a shared 8-byte head should match, got %x vs %x", fa.headHash, fb.headHash)
+	}
+}
+
+func TestComputeHeadHashShorterThanFile(t *testing.T) {
+	dir := t.TempDir()
+	short := filepath.Join(dir, "short")
+	writeFile(t, short, "hi")
+
+	f := File{path: short}
+	if err := f.computeHeadHash(context.Background(), 4096, xxh3Hasher{}); err != nil {
+		t.Fatalf("computeHeadHash: %v", err)
+	}
+	if len(f.headHash) == 0 {
+		t.Error("computeHeadHash left headHash empty for a file shorter than headSize")
+	}
+}
+
+func TestComputeHashStreamsInSmallBuffer(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "big")
+	content := make([]byte, 1<<20)
+	for i := range content {
+		content[i] = byte(i)
+	}
+	if err := os.WriteFile(path, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	hasher := xxh3Hasher{}
+	f := File{path: path}
+	if err := f.computeHash(context.Background(), 64, hasher); err != nil {
+		t.Fatalf("computeHash: %v", err)
+	}
+
+	h := hasher.New()
+	h.Write(content)
+	want := h.Sum(nil)
+	if string(f.fullHash) != string(want) {
+		t.Errorf("computeHash with a 64-byte buffer = %x, want %x", f.fullHash, want)
+	}
+}
+
+func TestComputeHashRespectsCanceledContext(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a")
+	writeFile(t, path, "payload")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	f := File{path: path}
+	if err := f.computeHash(ctx, 4096, xxh3Hasher{}); err == nil {
+		t.Error("computeHash with an already-canceled context should error")
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"hash"
+
+	"github.com/zeebo/xxh3"
+	"golang.org/x/crypto/blake2b"
+)
+
+// Hasher builds a fresh digest for a file pass. xxh3 (the default) is a
+// fast non-cryptographic hash with a real streaming hash.Hash
+// implementation, which the chunk0-1 bufSize-bounded streaming pass
+// depends on; MetroHash was considered but dropped, since
+// github.com/dgryski/go-metro only exposes a one-shot Hash64(buffer,
+// seed) and has no incremental API, so it can't back a streaming pass
+// without buffering the whole file first. blake2b and sha256 trade speed
+// for collision resistance, which matters once -action can delete or
+// replace files based on a match.
+type Hasher interface {
+	New() hash.Hash
+}
+
+type xxh3Hasher struct{}
+
+func (xxh3Hasher) New() hash.Hash { return xxh3.New() }
+
+type blake2bHasher struct{}
+
+func (blake2bHasher) New() hash.Hash {
+	h, _ := blake2b.New256(nil)
+	return h
+}
+
+type sha256Hasher struct{}
+
+func (sha256Hasher) New() hash.Hash { return sha256.New() }
+
+func hasherFor(name string) (Hasher, error) {
+	switch name {
+	case "xxh3", "":
+		return xxh3Hasher{}, nil
+	case "blake2b":
+		return blake2bHasher{}, nil
+	case "sha256":
+		return sha256Hasher{}, nil
+	default:
+		return nil, fmt.Errorf("unknown hash algorithm %q", name)
+	}
+}
@@ -0,0 +1,12 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package main
+
+import "os"
+
+// getDevIno has no (dev, inode) concept on this platform, so hardlink
+// detection is always a no-op here.
+func getDevIno(fi os.FileInfo) (dev, ino uint64) {
+	return 0, 0
+}
@@ -0,0 +1,20 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// getDevIno returns the device and inode identifying fi on disk, so that
+// multiple paths referring to the same underlying file (e.g. already
+// hardlinked) can be recognized as one file rather than as duplicates.
+func getDevIno(fi os.FileInfo) (dev, ino uint64) {
+	stat, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0
+	}
+	return uint64(stat.Dev), uint64(stat.Ino)
+}
@@ -1,206 +1,42 @@
 package main
 
 import (
-	"bufio"
-	"errors"
+	"context"
 	"flag"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"os"
-	"path/filepath"
+	"os/signal"
 	"runtime"
+	"sort"
 	"strconv"
 	"sync"
-
-	"github.com/dgryski/go-metro"
+	"syscall"
 )
 
-type File struct {
-	path      string
-	size      uint64
-	bytesHash uint64
-	fullHash  uint64
-}
-
-func FileFromPath(path string, buffSize int) (File, error) {
-	firstBytes := make([]byte, buffSize)
-	file, err := os.Open(path)
-	defer file.Close()
-
-	stats, _ := file.Stat()
-	if err != nil {
-		return File{}, err
-	}
-
-	_, err = file.ReadAt(firstBytes, 0)
-	if err != nil {
-		if err != io.EOF {
-			return File{}, err
-		}
-	}
-	return File{path: path, size: uint64(stats.Size()), bytesHash: metro.Hash64(firstBytes, 0)}, nil
-}
-
-type PathStore struct {
-	sync.Mutex
-	paths        map[uint64][]File
-	bytesPerFile int
-	pathsAdded map[uint64]bool
-}
-
-func NewPathStore(paths []string, bytesPerFile int) (*PathStore, error) {
-	store := &PathStore{paths: make(map[uint64][]File), pathsAdded: make(map[uint64]bool), bytesPerFile: bytesPerFile}
-	for _, p := range paths {
-		err := store.AddFile(p)
-		if err != nil {
-			return store, err
-		}
-	}
-	return store, nil
-}
-
-func (p *PathStore) PathAdded(path string) bool {
-	_, ok := p.pathsAdded[metro.Hash64([]byte(path), 0)]
-	if ok {
-		return true
-	}
-	return false
-}
-
-func (p *PathStore) AllPaths() []File {
-	var ps []File
-	p.Lock()
-	for _, v := range p.paths {
-		for _, x := range v {
-			ps = append(ps, x)
-		}
-	}
-	p.Unlock()
-	return ps
-}
-
-func (p *PathStore) EmptyFiles() []File {
-	var emptyFiles []File
-	p.Lock()
-	for _, v := range p.paths {
-		for _, f := range v {
-			if f.size == 0 {
-				emptyFiles = append(emptyFiles, f)
-			}
-		}
-	}
-	p.Unlock()
-	return emptyFiles
-}
-
-func (p *PathStore) AddFile(s string) error {
-	if p.PathAdded(s) {
-		return errors.New("Path already present")
-	}
-	f, err := FileFromPath(s, p.bytesPerFile)
-	if err != nil {
-		return err
-	}
-	p.Lock()
-	p.paths[f.bytesHash] = append(p.paths[f.bytesHash], f)
-	p.Unlock()
-	return nil
-}
-
-func (p *PathStore) FileCount() int64 {
-	count := 0
-	p.Lock()
-	for _, v := range p.paths {
-		count += len(v)
-	}
-	p.Unlock()
-	return int64(count)
-}
-
-func (p *PathStore) FileSetCount() int64 {
-	p.Lock()
-	length := len(p.paths)
-	p.Unlock()
-	return int64(length)
-}
-
-func (p *PathStore) Prune() *PathStore {
-	newStore := &PathStore{paths: make(map[uint64][]File), pathsAdded: make(map[uint64]bool), bytesPerFile: p.bytesPerFile}
-	p.Lock()
-	for k, v := range p.paths {
-		if len(v) > 1 {
-			newStore.paths[k] = v
-		}
-	}
-	p.Unlock()
-	return newStore
-}
-
-func (p *PathStore) TotalSizeDups() int64 {
-	total := 0
-	p.Lock()
-	for _, v := range p.paths {
-		// We only want the size of the duplicated files, so the size of the duplicates
-		// is the size of the files (since they all have the same size) multiplied
-		// by the number of times the file is duplicated (1 less than the total number of files in the set)
-		total += (len(v) - 1) * int(v[0].size)
-	}
-	p.Unlock()
-	return int64(total)
-}
-
-func (p *PathStore) Summarize() string {
-	numFiles := strconv.FormatInt(int64(p.FileCount()), 10)
-	numSets := strconv.FormatInt(int64(p.FileSetCount()), 10)
-	sizeMegabytes := strconv.FormatInt(p.TotalSizeDups()/(1024*1024), 10)
-	return numFiles + " files (in " + numSets + " sets), occupying " + sizeMegabytes + " megabytes"
-}
-
-//TODO(Adam Drake): have this take a channel of paths and a return channel of files?
-func fromSTDIn() *PathStore {
-	scr := bufio.NewScanner(bufio.NewReader(os.Stdin))
-	themap := PathStore{paths: make(map[uint64][]File)}
-	for scr.Scan() {
-		err := themap.AddFile(scr.Text())
-
-		if err != nil {
-			errOut(err)
-		}
-	}
-	return themap.Prune()
-}
-
-func fileCheck(paths *[]string, path string, info os.FileInfo, err error) error {
-	if err != nil || info.IsDir() || (info.Mode()&os.ModeSymlink == os.ModeSymlink) {
-		return nil
-	}
-	*paths = append(*paths, path)
-	return nil
-}
-
-func dirWalk(start string) ([]string, error) {
-	var paths []string
-	fileFunc := func(path string, fi os.FileInfo, err error) error {
-		return fileCheck(&paths, path, fi, err)
-	}
-	err := filepath.Walk(start, fileFunc)
-	if err != nil {
-		return paths, err
-	}
-	return paths, nil
-
-}
-
-func hashWorker(inq chan File, res chan File, wg *sync.WaitGroup) {
+func hashWorker(ctx context.Context, inq <-chan File, res chan<- File, wg *sync.WaitGroup, bufSize int, hasher Hasher, progress *StageProgress) {
 	defer wg.Done()
-	for f := range inq {
-		data, err := ioutil.ReadFile(f.path) //TODO(Adam Drake): convert this to a streaming hash to save memory
-		if err != nil {
-			errOut(err)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case f, ok := <-inq:
+			if !ok {
+				return
+			}
+			if err := f.computeHash(ctx, bufSize, hasher); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				errOut(err)
+			}
+			progress.AddDone(1)
+			progress.AddBytes(f.size)
+			select {
+			case res <- f:
+			case <-ctx.Done():
+				return
+			}
 		}
-		f.fullHash = metro.Hash64(data, 0)
-		res <- f
 	}
 }
 
@@ -210,73 +46,167 @@ func errOut(e error) {
 }
 
 func main() {
-	stdin := flag.Bool("stdin", false, "Read pathes from STDIN?")
+	stdin := flag.Bool("stdin", false, "Read paths from STDIN?")
 	path := flag.String("path", "", "Starting path")
 	//recurse := flag.Bool("r", false, "Walk the directory tree recursively?") //TODO(Adam Drake): enable toggle and make sure to accept N for levels of recursion
 	numWorkers := flag.Int("workers", 2*runtime.NumCPU(), "Number of workers for hashing")
-	numBytes := flag.Int("bytes", 4096, "Compare the first X bytes of each file")
+	queueSize := flag.Int("queue", 1024, "Buffer size for the channel feeding each pipeline stage")
+	headSize := flag.Int("headsize", 4096, "Compare the first X bytes of each file")
+	bufSize := flag.Int("bufsize", 128*1024, "Size of the buffer used to stream-hash file contents")
+	minSize := flag.Int64("minsize", 0, "Skip files smaller than this many bytes")
 	summarize := flag.Bool("summarize", false, "Output only summary statistics")
+	action := flag.String("action", "print", "What to do with duplicates: print, symlink, hardlink, or delete")
+	basedir := flag.String("basedir", "", "Tree whose files are preferred as the canonical copy")
+	dupdir := flag.String("dupdir", "", "Only replace duplicates found under this tree")
+	chmodFlag := flag.String("chmod", "", "Re-permission replacements to this octal mode, e.g. 644 (leave unchanged if empty)")
+	fsync := flag.Bool("fsync", false, "Fsync the containing directory after each replacement")
+	hardlinks := flag.String("hardlinks", "skip", "How to treat paths already sharing a (dev, inode): skip, group, or ignore")
+	timeout := flag.Duration("timeout", 0, "Abort the run after this long (0 to disable, for scripted use)")
+	hashName := flag.String("hash", "xxh3", "Hash algorithm for content digests: xxh3, blake2b, or sha256")
+	verify := flag.Bool("verify", false, "Byte-compare every surviving duplicate set before acting, to rule out a hash collision")
+	progressFormat := flag.String("progress", "text", "Progress reporting format: text or json")
+	benchmark := flag.Bool("benchmark", false, "Run the pipeline against a synthetic duplicate tree and report throughput per stage")
 	flag.Parse()
 
+	hasher, err := hasherFor(*hashName)
+	if err != nil {
+		errOut(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	if *benchmark {
+		if err := runBenchmark(ctx, *numWorkers, *queueSize, *headSize, *bufSize, hasher); err != nil {
+			errOut(err)
+		}
+		return
+	}
+
 	if *stdin && (*path != "") {
 		fmt.Println("Only one of -path or -stdin may be used")
 		os.Exit(1)
 	}
 
+	progress := &Progress{}
+	progressCtx, stopProgress := context.WithCancel(ctx)
+	defer stopProgress()
+	// out serializes writes against reportProgress's own goroutine, since
+	// it and the inter-stage summaries below both write to os.Stdout for
+	// as long as the progress reporter is still running.
+	out := &syncWriter{w: os.Stdout}
+	go reportProgress(progressCtx, progress, *progressFormat, out)
 
-	var bytesMatch *PathStore
+	var paths []string
 	if *stdin {
 		fmt.Println("getting paths from stdin")
-		bytesMatch = fromSTDIn()
+		paths = pathsFromSTDIn(ctx)
 	} else {
-
-		paths, err := dirWalk(*path)
-		if err != nil {
-			errOut(err)
-		}
-		bytesMatch, err = NewPathStore(paths, *numBytes)
-		if err != nil {
+		paths, err = dirWalk(ctx, *path)
+		// A canceled ctx surfaces here as err too; walk whatever paths were
+		// collected before the cancellation through the rest of the
+		// pipeline rather than discarding them, so partial results still
+		// get reported below. Any other error is still fatal.
+		if err != nil && ctx.Err() == nil {
 			errOut(err)
 		}
 	}
 
-	fmt.Println(bytesMatch.Summarize())
+	// Pass 1: group by size alone, no file I/O beyond os.Lstat.
+	sizeStore, err := NewSizeStore(ctx, paths, *minSize, *hardlinks, *numWorkers, *queueSize, &progress.Size)
+	if err != nil && ctx.Err() == nil {
+		errOut(err)
+	}
+	fmt.Fprintln(out, sizeStore.Summarize())
+	sizeMatched := sizeStore.Prune()
 
-	//current key is hash of first X bytes of file
-	newStore := bytesMatch.Prune()
-	fmt.Println(newStore.Summarize())
+	// Pass 2: for files that share a size, group by a small head-block hash.
+	headStore, err := NewPathStore(ctx, sizeMatched.AllFiles(), *headSize, hasher, *numWorkers, *queueSize, &progress.HeadHash)
+	if err != nil && ctx.Err() == nil {
+		errOut(err)
+	}
+	fmt.Fprintln(out, headStore.Summarize())
+	headMatched := headStore.Prune()
 
-	//do hashing of each file
+	// Pass 3: for files that also share a head hash, stream-hash the whole file.
 	var wg sync.WaitGroup
-	hashq := make(chan File)
-	resultq := make(chan File)
-	go func() {
-		wg.Wait()
-		close(resultq)
-	}()
-	hashed := &PathStore{paths: make(map[uint64][]File)}
+	hashq := make(chan File, *queueSize)
+	resultq := make(chan File, *queueSize)
+	hashed := &PathStore{paths: make(map[string][]File), hasher: hasher}
 
-	fmt.Println("got the hashed map")
+	var fullHashTodo int64
+	for _, v := range headMatched.paths {
+		fullHashTodo += int64(len(v))
+	}
+	progress.FullHash.AddTodo(fullHashTodo)
 
 	go func() {
-		for _, v := range newStore.paths {
+		defer close(hashq)
+		for _, v := range headMatched.paths {
+			// Sorting by inode keeps sequential reads of a bucket physically
+			// close together on rotational disks.
+			sort.Slice(v, func(i, j int) bool { return v[i].ino < v[j].ino })
 			for _, f := range v {
-				hashq <- f
+				select {
+				case hashq <- f:
+				case <-ctx.Done():
+					return
+				}
 			}
 		}
-		close(hashq)
 	}()
 
 	for i := 0; i < *numWorkers; i++ {
 		wg.Add(1)
-		go hashWorker(hashq, resultq, &wg)
+		go hashWorker(ctx, hashq, resultq, &wg, *bufSize, hasher, &progress.FullHash)
 	}
+	go func() {
+		wg.Wait()
+		close(resultq)
+	}()
 
 	for f := range resultq {
-		hashed.paths[f.fullHash] = append(hashed.paths[f.fullHash], f)
+		key := string(f.fullHash)
+		hashed.paths[key] = append(hashed.paths[key], f)
 	}
 	hashedStore := hashed.Prune()
+	stopProgress()
+
+	if *verify {
+		verified := &PathStore{paths: make(map[string][]File), hasher: hasher}
+		for k, v := range hashedStore.paths {
+			matched, err := verifySet(v)
+			if err != nil {
+				errOut(err)
+			}
+			if len(matched) > 1 || hasAliases(matched) {
+				verified.paths[k] = matched
+			}
+		}
+		hashedStore = verified
+	}
+
 	if *summarize {
 		fmt.Println(hashedStore.Summarize())
 	}
+
+	if ctx.Err() != nil {
+		fmt.Println("interrupted, reporting partial results:", ctx.Err())
+	}
+
+	chmod := 0
+	if *chmodFlag != "" {
+		parsed, err := strconv.ParseInt(*chmodFlag, 8, 32)
+		if err != nil {
+			errOut(err)
+		}
+		chmod = int(parsed)
+	}
+	applyAction(Action(*action), hashedStore, *basedir, *dupdir, chmod, *fsync, os.Stdout)
 }
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+
+	"github.com/dgryski/go-metro"
+)
+
+// PathStore buckets files by a string-encoded digest. NewPathStore builds
+// the head-hash pass (keying on File.headHash) from the files that
+// survived SizeStore; main builds a second PathStore directly, keying on
+// File.fullHash, from the files that survive this one.
+type PathStore struct {
+	sync.Mutex
+	paths      map[string][]File
+	headSize   int
+	hasher     Hasher
+	pathsAdded map[uint64]bool
+}
+
+// NewPathStore builds the head-hash pass using a bounded pool of workers
+// workers, each pulling from a channel buffered to queueSize, so a slow
+// downstream stage applies backpressure instead of every head read
+// firing off at once.
+func NewPathStore(ctx context.Context, files []File, headSize int, hasher Hasher, workers, queueSize int, progress *StageProgress) (*PathStore, error) {
+	store := &PathStore{paths: make(map[string][]File), pathsAdded: make(map[uint64]bool), headSize: headSize, hasher: hasher}
+	progress.AddTodo(int64(len(files)))
+
+	fileq := make(chan File, queueSize)
+	errq := make(chan error, 1)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range fileq {
+				if err := store.AddFile(ctx, f); err != nil {
+					select {
+					case errq <- err:
+					default:
+					}
+				}
+				progress.AddDone(1)
+				progress.AddBytes(int64(headSize))
+			}
+		}()
+	}
+
+feed:
+	for _, f := range files {
+		select {
+		case fileq <- f:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(fileq)
+	wg.Wait()
+
+	select {
+	case err := <-errq:
+		return store, err
+	default:
+	}
+	return store, ctx.Err()
+}
+
+func (p *PathStore) PathAdded(path string) bool {
+	_, ok := p.pathsAdded[metro.Hash64([]byte(path), 0)]
+	return ok
+}
+
+func (p *PathStore) AddFile(ctx context.Context, f File) error {
+	if err := f.computeHeadHash(ctx, p.headSize, p.hasher); err != nil {
+		return err
+	}
+	key := string(f.headHash)
+	pathKey := metro.Hash64([]byte(f.path), 0)
+
+	p.Lock()
+	defer p.Unlock()
+	if p.pathsAdded[pathKey] {
+		return errors.New("Path already present")
+	}
+	p.pathsAdded[pathKey] = true
+	p.paths[key] = append(p.paths[key], f)
+	return nil
+}
+
+func (p *PathStore) AllPaths() []File {
+	var ps []File
+	p.Lock()
+	for _, v := range p.paths {
+		ps = append(ps, v...)
+	}
+	p.Unlock()
+	return ps
+}
+
+func (p *PathStore) EmptyFiles() []File {
+	var emptyFiles []File
+	p.Lock()
+	for _, v := range p.paths {
+		for _, f := range v {
+			if f.size == 0 {
+				emptyFiles = append(emptyFiles, f)
+			}
+		}
+	}
+	p.Unlock()
+	return emptyFiles
+}
+
+func (p *PathStore) FileCount() int64 {
+	count := 0
+	p.Lock()
+	for _, v := range p.paths {
+		count += len(v)
+	}
+	p.Unlock()
+	return int64(count)
+}
+
+func (p *PathStore) FileSetCount() int64 {
+	p.Lock()
+	length := len(p.paths)
+	p.Unlock()
+	return int64(length)
+}
+
+// Prune discards hash buckets with only a single member and no aliases,
+// the same rule SizeStore.Prune applies: a lone representative that
+// absorbed aliases under -hardlinks=group is still a duplicate set.
+func (p *PathStore) Prune() *PathStore {
+	newStore := &PathStore{paths: make(map[string][]File), pathsAdded: make(map[uint64]bool), headSize: p.headSize, hasher: p.hasher}
+	p.Lock()
+	for k, v := range p.paths {
+		if len(v) > 1 || hasAliases(v) {
+			newStore.paths[k] = v
+		}
+	}
+	p.Unlock()
+	return newStore
+}
+
+func (p *PathStore) TotalSizeDups() int64 {
+	total := int64(0)
+	p.Lock()
+	for _, v := range p.paths {
+		// We only want the size of the duplicated files, so the size of the duplicates
+		// is the size of the files (since they all have the same size) multiplied
+		// by the number of times the file is duplicated (1 less than the total number of files in the set)
+		total += int64(len(v)-1) * v[0].size
+	}
+	p.Unlock()
+	return total
+}
+
+func (p *PathStore) Summarize() string {
+	numFiles := strconv.FormatInt(p.FileCount(), 10)
+	numSets := strconv.FormatInt(p.FileSetCount(), 10)
+	sizeMegabytes := strconv.FormatInt(p.TotalSizeDups()/(1024*1024), 10)
+	return numFiles + " files (in " + numSets + " sets), occupying " + sizeMegabytes + " megabytes"
+}
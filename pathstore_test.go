@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestPathStoreGroupsByHeadHashAndPrunesSingletons(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a")
+	b := filepath.Join(dir, "b")
+	uniqueHead := filepath.Join(dir, "unique")
+	writeFile(t, a, "shared-head-aaaa")
+	writeFile(t, b, "shared-head-bbbb")
+	writeFile(t, uniqueHead, "totally different content")
+
+	hasher := xxh3Hasher{}
+	files := []File{{path: a, size: 16}, {path: b, size: 16}, {path: uniqueHead, size: 25}}
+
+	var progress StageProgress
+	store, err := NewPathStore(context.Background(), files, 11, hasher, 2, 8, &progress)
+	if err != nil {
+		t.Fatalf("NewPathStore: %v", err)
+	}
+	if got := store.FileCount(); got != 3 {
+		t.Fatalf("FileCount() = %d, want 3", got)
+	}
+
+	pruned := store.Prune()
+	remaining := pruned.AllPaths()
+	if len(remaining) != 2 {
+		t.Fatalf("Prune().AllPaths() = %v, want 2 (unique head dropped)", remaining)
+	}
+	for _, f := range remaining {
+		if f.path == uniqueHead {
+			t.Errorf("Prune() kept the head-unique file %q", uniqueHead)
+		}
+	}
+}
+
+func TestPathStoreAddFileRejectsDuplicatePath(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a")
+	writeFile(t, a, "payload")
+
+	store := &PathStore{paths: make(map[string][]File), pathsAdded: make(map[uint64]bool), headSize: 4096, hasher: xxh3Hasher{}}
+	f := File{path: a, size: 7}
+	if err := store.AddFile(context.Background(), f); err != nil {
+		t.Fatalf("AddFile: %v", err)
+	}
+	if !store.PathAdded(a) {
+		t.Error("PathAdded() = false after AddFile")
+	}
+	if err := store.AddFile(context.Background(), f); err == nil {
+		t.Error("AddFile() on an already-added path should error")
+	}
+}
+
+func TestPathStoreTotalSizeDups(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a")
+	b := filepath.Join(dir, "b")
+	writeFile(t, a, "dup-content")
+	writeFile(t, b, "dup-content")
+
+	store := &PathStore{paths: make(map[string][]File)}
+	store.paths["k"] = []File{{path: a, size: 11}, {path: b, size: 11}}
+
+	if got, want := store.TotalSizeDups(), int64(11); got != want {
+		t.Errorf("TotalSizeDups() = %d, want %d", got, want)
+	}
+}
+
+func TestPathStoreEmptyFiles(t *testing.T) {
+	store := &PathStore{paths: make(map[string][]File)}
+	store.paths["k"] = []File{{path: "empty", size: 0}, {path: "nonempty", size: 5}}
+
+	empty := store.EmptyFiles()
+	if len(empty) != 1 || empty[0].path != "empty" {
+		t.Errorf("EmptyFiles() = %v, want only the zero-size file", empty)
+	}
+}
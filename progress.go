@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// syncWriter serializes writes from multiple goroutines to a shared
+// io.Writer, so reportProgress's ticking updates can't interleave
+// mid-line with another goroutine's writes to the same destination
+// (e.g. main's inter-stage summary lines to os.Stdout).
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
+
+// StageProgress tracks todo/done/bytesHashed counters for a single
+// pipeline stage. Workers update it via atomics so reporting never needs
+// to take a lock shared with the hot path.
+type StageProgress struct {
+	Todo        int64
+	Done        int64
+	BytesHashed int64
+}
+
+func (s *StageProgress) AddTodo(n int64)  { atomic.AddInt64(&s.Todo, n) }
+func (s *StageProgress) AddDone(n int64)  { atomic.AddInt64(&s.Done, n) }
+func (s *StageProgress) AddBytes(n int64) { atomic.AddInt64(&s.BytesHashed, n) }
+
+func (s *StageProgress) snapshot() (todo, done, bytesHashed int64) {
+	return atomic.LoadInt64(&s.Todo), atomic.LoadInt64(&s.Done), atomic.LoadInt64(&s.BytesHashed)
+}
+
+// Progress tracks counters for every stage of the pipeline.
+type Progress struct {
+	Size     StageProgress
+	HeadHash StageProgress
+	FullHash StageProgress
+}
+
+func (p *Progress) stages() []struct {
+	name string
+	sp   *StageProgress
+} {
+	return []struct {
+		name string
+		sp   *StageProgress
+	}{
+		{"size", &p.Size},
+		{"headhash", &p.HeadHash},
+		{"fullhash", &p.FullHash},
+	}
+}
+
+type progressEvent struct {
+	Stage       string `json:"stage"`
+	Todo        int64  `json:"todo"`
+	Done        int64  `json:"done"`
+	BytesHashed int64  `json:"bytesHashed,omitempty"`
+}
+
+// reportProgress emits a progress update every 250ms until ctx is
+// canceled: a single human-readable line rewritten in place with \r, or
+// one newline-delimited JSON event per stage when format is "json".
+func reportProgress(ctx context.Context, p *Progress, format string, out io.Writer) {
+	ticker := time.NewTicker(250 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			emitProgress(p, format, out)
+		}
+	}
+}
+
+func emitProgress(p *Progress, format string, out io.Writer) {
+	if format == "json" {
+		for _, st := range p.stages() {
+			todo, done, bytesHashed := st.sp.snapshot()
+			b, err := json.Marshal(progressEvent{Stage: st.name, Todo: todo, Done: done, BytesHashed: bytesHashed})
+			if err != nil {
+				continue
+			}
+			fmt.Fprintln(out, string(b))
+		}
+		return
+	}
+
+	line := "\r"
+	for _, st := range p.stages() {
+		todo, done, _ := st.sp.snapshot()
+		line += fmt.Sprintf("%s %d/%d  ", st.name, done, todo)
+	}
+	fmt.Fprint(out, line)
+}
@@ -0,0 +1,10 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package main
+
+// checkSameDevice has no (dev) concept on this platform, so cross-device
+// hardlink detection is always a no-op here.
+func checkSameDevice(canonicalPath, dupDir string) error {
+	return nil
+}
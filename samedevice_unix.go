@@ -0,0 +1,35 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// checkSameDevice refuses a hardlink across filesystems: the canonical
+// file and the directory receiving the link must report the same device.
+func checkSameDevice(canonicalPath, dupDir string) error {
+	canonInfo, err := os.Stat(canonicalPath)
+	if err != nil {
+		return err
+	}
+	dirInfo, err := os.Stat(dupDir)
+	if err != nil {
+		return err
+	}
+	canonStat, ok := canonInfo.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	dirStat, ok := dirInfo.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	if canonStat.Dev != dirStat.Dev {
+		return fmt.Errorf("cannot hardlink across devices: %s and %s", canonicalPath, dupDir)
+	}
+	return nil
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/dgryski/go-metro"
+)
+
+// inodeLoc locates a previously-added File within SizeStore.sizes, so a
+// later path sharing the same (dev, ino) can be attached as an alias
+// without invalidating earlier slice growth.
+type inodeLoc struct {
+	size int64
+	idx  int
+}
+
+// SizeStore groups files by size alone, with no file-content I/O. A
+// file's size is available from os.Lstat, so building this store never
+// opens a single file; anything whose size doesn't collide with another
+// file's can never be a duplicate, and Prune discards it before it's
+// ever read.
+//
+// It also recognizes paths that are already hardlinked to each other
+// (same device and inode): those are one file on disk, not duplicates,
+// and hardlinks controls how they're handled ("skip" drops the repeat
+// path, "group" attaches it as an alias of the first path seen, "ignore"
+// treats every path as independent, matching the old behavior).
+type SizeStore struct {
+	sync.Mutex
+	sizes      map[int64][]File
+	minSize    int64
+	hardlinks  string
+	pathsAdded map[uint64]bool
+	seenInodes map[[2]uint64]inodeLoc
+}
+
+// NewSizeStore builds a SizeStore from paths using a bounded pool of
+// workers workers, each pulling from a channel buffered to queueSize so
+// a slow downstream stage (or a canceled ctx) applies backpressure
+// instead of this stage racing ahead to stat every path up front.
+func NewSizeStore(ctx context.Context, paths []string, minSize int64, hardlinks string, workers, queueSize int, progress *StageProgress) (*SizeStore, error) {
+	store := &SizeStore{
+		sizes:      make(map[int64][]File),
+		pathsAdded: make(map[uint64]bool),
+		seenInodes: make(map[[2]uint64]inodeLoc),
+		minSize:    minSize,
+		hardlinks:  hardlinks,
+	}
+	progress.AddTodo(int64(len(paths)))
+
+	pathq := make(chan string, queueSize)
+	errq := make(chan error, 1)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range pathq {
+				if err := store.AddFile(p); err != nil {
+					select {
+					case errq <- err:
+					default:
+					}
+				}
+				progress.AddDone(1)
+			}
+		}()
+	}
+
+feed:
+	for _, p := range paths {
+		select {
+		case pathq <- p:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(pathq)
+	wg.Wait()
+
+	select {
+	case err := <-errq:
+		return store, err
+	default:
+	}
+	return store, ctx.Err()
+}
+
+func (s *SizeStore) PathAdded(path string) bool {
+	_, ok := s.pathsAdded[metro.Hash64([]byte(path), 0)]
+	return ok
+}
+
+func (s *SizeStore) AddFile(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	pathKey := metro.Hash64([]byte(path), 0)
+	if s.pathsAdded[pathKey] {
+		return errors.New("Path already present")
+	}
+	s.pathsAdded[pathKey] = true
+
+	if info.Size() < s.minSize {
+		return nil
+	}
+
+	dev, ino := getDevIno(info)
+	hasInode := dev != 0 || ino != 0
+	inodeKey := [2]uint64{dev, ino}
+
+	if hasInode && s.hardlinks != "ignore" {
+		if loc, ok := s.seenInodes[inodeKey]; ok {
+			if s.hardlinks == "group" {
+				s.sizes[loc.size][loc.idx].aliases = append(s.sizes[loc.size][loc.idx].aliases, path)
+			}
+			return nil
+		}
+	}
+
+	f := File{path: path, size: info.Size(), dev: dev, ino: ino}
+	s.sizes[f.size] = append(s.sizes[f.size], f)
+	if hasInode {
+		s.seenInodes[inodeKey] = inodeLoc{size: f.size, idx: len(s.sizes[f.size]) - 1}
+	}
+	return nil
+}
+
+func (s *SizeStore) AllFiles() []File {
+	var fs []File
+	s.Lock()
+	for _, v := range s.sizes {
+		fs = append(fs, v...)
+	}
+	s.Unlock()
+	return fs
+}
+
+func (s *SizeStore) FileCount() int64 {
+	count := 0
+	s.Lock()
+	for _, v := range s.sizes {
+		count += len(v)
+	}
+	s.Unlock()
+	return int64(count)
+}
+
+func (s *SizeStore) FileSetCount() int64 {
+	s.Lock()
+	length := len(s.sizes)
+	s.Unlock()
+	return int64(length)
+}
+
+// Prune discards size buckets with only a single member and no aliases:
+// a file that is unique by size, with no other path hardlinked to it,
+// cannot have a duplicate. A lone representative that absorbed aliases
+// under -hardlinks=group is kept, since those alias paths are still
+// duplicates of it.
+func (s *SizeStore) Prune() *SizeStore {
+	newStore := &SizeStore{sizes: make(map[int64][]File), pathsAdded: s.pathsAdded, minSize: s.minSize, hardlinks: s.hardlinks}
+	s.Lock()
+	for k, v := range s.sizes {
+		if len(v) > 1 || hasAliases(v) {
+			newStore.sizes[k] = v
+		}
+	}
+	s.Unlock()
+	return newStore
+}
+
+func hasAliases(files []File) bool {
+	for _, f := range files {
+		if len(f.aliases) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *SizeStore) Summarize() string {
+	numFiles := strconv.FormatInt(s.FileCount(), 10)
+	numSets := strconv.FormatInt(s.FileSetCount(), 10)
+	return numFiles + " files (in " + numSets + " size-matched sets)"
+}
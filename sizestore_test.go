@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSizeStoreGroupsBySizeAndPrunesSingletons(t *testing.T) {
+	dir := t.TempDir()
+	small1 := filepath.Join(dir, "small1")
+	small2 := filepath.Join(dir, "small2")
+	unique := filepath.Join(dir, "unique")
+	writeFile(t, small1, "aaaa")
+	writeFile(t, small2, "bbbb") // same size as small1, different content
+	writeFile(t, unique, "a single unique size")
+
+	var progress StageProgress
+	store, err := NewSizeStore(context.Background(), []string{small1, small2, unique}, 0, "skip", 2, 8, &progress)
+	if err != nil {
+		t.Fatalf("NewSizeStore: %v", err)
+	}
+	if got := store.FileCount(); got != 3 {
+		t.Fatalf("FileCount() = %d, want 3", got)
+	}
+
+	pruned := store.Prune()
+	if got := pruned.FileCount(); got != 2 {
+		t.Errorf("Prune().FileCount() = %d, want 2 (unique size dropped)", got)
+	}
+	for _, f := range pruned.AllFiles() {
+		if f.path == unique {
+			t.Errorf("Prune() kept the size-unique file %q", unique)
+		}
+	}
+}
+
+func TestSizeStoreSkipsBelowMinSize(t *testing.T) {
+	dir := t.TempDir()
+	tiny := filepath.Join(dir, "tiny")
+	writeFile(t, tiny, "x")
+
+	var progress StageProgress
+	store, err := NewSizeStore(context.Background(), []string{tiny}, 10, "skip", 1, 8, &progress)
+	if err != nil {
+		t.Fatalf("NewSizeStore: %v", err)
+	}
+	if got := store.FileCount(); got != 0 {
+		t.Errorf("FileCount() = %d, want 0 for a file under -minsize", got)
+	}
+}
+
+func TestSizeStoreHardlinksSkip(t *testing.T) {
+	dir := t.TempDir()
+	original := filepath.Join(dir, "original")
+	linked := filepath.Join(dir, "linked")
+	writeFile(t, original, "payload")
+	if err := os.Link(original, linked); err != nil {
+		t.Skipf("hardlinks unsupported in this environment: %v", err)
+	}
+
+	var progress StageProgress
+	store, err := NewSizeStore(context.Background(), []string{original, linked}, 0, "skip", 1, 8, &progress)
+	if err != nil {
+		t.Fatalf("NewSizeStore: %v", err)
+	}
+	if got := store.FileCount(); got != 1 {
+		t.Errorf("FileCount() = %d, want 1 with -hardlinks=skip", got)
+	}
+}
+
+func TestSizeStoreHardlinksGroupKeepsAliasedSingleton(t *testing.T) {
+	dir := t.TempDir()
+	original := filepath.Join(dir, "original")
+	linked := filepath.Join(dir, "linked")
+	unique := filepath.Join(dir, "unique")
+	writeFile(t, original, "payload")
+	writeFile(t, unique, "a totally different size entirely")
+	if err := os.Link(original, linked); err != nil {
+		t.Skipf("hardlinks unsupported in this environment: %v", err)
+	}
+
+	var progress StageProgress
+	store, err := NewSizeStore(context.Background(), []string{original, linked, unique}, 0, "group", 1, 8, &progress)
+	if err != nil {
+		t.Fatalf("NewSizeStore: %v", err)
+	}
+
+	pruned := store.Prune()
+	files := pruned.AllFiles()
+	if len(files) != 1 {
+		t.Fatalf("Prune().AllFiles() = %v, want the original kept with its alias", files)
+	}
+	if len(files[0].aliases) != 1 || files[0].aliases[0] != linked {
+		t.Errorf("aliases = %v, want [%q]", files[0].aliases, linked)
+	}
+}
+
+func TestSizeStorePathAddedRejectsDuplicateAdd(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a")
+	writeFile(t, path, "payload")
+
+	store := &SizeStore{sizes: make(map[int64][]File), pathsAdded: make(map[uint64]bool), seenInodes: make(map[[2]uint64]inodeLoc), hardlinks: "skip"}
+	if err := store.AddFile(path); err != nil {
+		t.Fatalf("AddFile: %v", err)
+	}
+	if !store.PathAdded(path) {
+		t.Error("PathAdded() = false after AddFile")
+	}
+	if err := store.AddFile(path); err == nil {
+		t.Error("AddFile() on an already-added path should error")
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+)
+
+const verifyChunkSize = 64 * 1024
+
+// verifySet does a streaming byte-by-byte comparison of every file in a
+// duplicate set against the first, eliminating any residual risk of a
+// hash collision before -action acts on the set. Files that turn out not
+// to match are dropped; what's left (possibly just the first file, if
+// everything else was a false positive) is returned.
+func verifySet(files []File) ([]File, error) {
+	if len(files) < 2 {
+		return files, nil
+	}
+	canonical := files[0]
+	matched := []File{canonical}
+	for _, f := range files[1:] {
+		same, err := filesEqual(canonical.path, f.path)
+		if err != nil {
+			return nil, err
+		}
+		if same {
+			matched = append(matched, f)
+		}
+	}
+	return matched, nil
+}
+
+func filesEqual(a, b string) (bool, error) {
+	fa, err := os.Open(a)
+	if err != nil {
+		return false, err
+	}
+	defer fa.Close()
+	fb, err := os.Open(b)
+	if err != nil {
+		return false, err
+	}
+	defer fb.Close()
+
+	ra := bufio.NewReaderSize(fa, verifyChunkSize)
+	rb := bufio.NewReaderSize(fb, verifyChunkSize)
+	bufA := make([]byte, verifyChunkSize)
+	bufB := make([]byte, verifyChunkSize)
+
+	for {
+		na, erra := io.ReadFull(ra, bufA)
+		nb, errb := io.ReadFull(rb, bufB)
+		if na != nb || !bytes.Equal(bufA[:na], bufB[:nb]) {
+			return false, nil
+		}
+
+		doneA := erra == io.EOF || erra == io.ErrUnexpectedEOF
+		doneB := errb == io.EOF || errb == io.ErrUnexpectedEOF
+		if doneA != doneB {
+			return false, nil
+		}
+		if doneA {
+			return true, nil
+		}
+		if erra != nil {
+			return false, erra
+		}
+		if errb != nil {
+			return false, errb
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestVerifySetDropsHashCollisionFalsePositive(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a")
+	b := filepath.Join(dir, "b")
+	writeFile(t, a, "payload-one")
+	writeFile(t, b, "payload-two")
+
+	// Same size and (simulated) fullHash, as if a and b had collided, but
+	// their actual content differs.
+	matched, err := verifySet([]File{{path: a}, {path: b}})
+	if err != nil {
+		t.Fatalf("verifySet: %v", err)
+	}
+	if len(matched) != 1 || matched[0].path != a {
+		t.Errorf("verifySet() = %v, want only %q kept", matched, a)
+	}
+}
+
+func TestVerifySetKeepsRealDuplicates(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a")
+	b := filepath.Join(dir, "b")
+	writeFile(t, a, "identical payload")
+	writeFile(t, b, "identical payload")
+
+	matched, err := verifySet([]File{{path: a}, {path: b}})
+	if err != nil {
+		t.Fatalf("verifySet: %v", err)
+	}
+	if len(matched) != 2 {
+		t.Errorf("verifySet() = %v, want both files kept", matched)
+	}
+}
+
+func TestVerifySetSingletonIsNoop(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a")
+	writeFile(t, a, "payload")
+
+	matched, err := verifySet([]File{{path: a}})
+	if err != nil {
+		t.Fatalf("verifySet: %v", err)
+	}
+	if len(matched) != 1 {
+		t.Errorf("verifySet() = %v, want the lone file unchanged", matched)
+	}
+}
+
+func TestFilesEqualDifferentLengths(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a")
+	b := filepath.Join(dir, "b")
+	writeFile(t, a, "short")
+	writeFile(t, b, "a good bit longer than short")
+
+	same, err := filesEqual(a, b)
+	if err != nil {
+		t.Fatalf("filesEqual: %v", err)
+	}
+	if same {
+		t.Error("filesEqual() = true for files of different lengths")
+	}
+}
+
+func TestFilesEqualMissingFile(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a")
+	writeFile(t, a, "payload")
+
+	if _, err := filesEqual(a, filepath.Join(dir, "missing")); err == nil {
+		t.Error("filesEqual() with a missing path should error")
+	}
+}
+
+// TestVerifySetKeepsAliasedSingleton covers the hasAliases check in main's
+// -verify loop: a duplicate set that collapses to one real path after
+// verification must still be reported if that path absorbed hardlink
+// aliases under -hardlinks=group, since those aliases are still
+// duplicates of it.
+func TestVerifySetKeepsAliasedSingleton(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a")
+	writeFile(t, a, "payload")
+
+	matched, err := verifySet([]File{{path: a, aliases: []string{filepath.Join(dir, "a-hardlink")}}})
+	if err != nil {
+		t.Fatalf("verifySet: %v", err)
+	}
+	if !hasAliases(matched) {
+		t.Errorf("verifySet() = %v, want aliases preserved on the surviving file", matched)
+	}
+}
+
+func TestHasherForUnknown(t *testing.T) {
+	if _, err := hasherFor("not-a-real-hasher"); err == nil {
+		t.Error("hasherFor() with an unknown name should error")
+	}
+}
+
+func TestHashersProduceStableDigests(t *testing.T) {
+	for _, name := range []string{"xxh3", "blake2b", "sha256"} {
+		t.Run(name, func(t *testing.T) {
+			hasher, err := hasherFor(name)
+			if err != nil {
+				t.Fatalf("hasherFor(%q): %v", name, err)
+			}
+			h1 := hasher.New()
+			h1.Write([]byte("payload"))
+			h2 := hasher.New()
+			h2.Write([]byte("payload"))
+			if string(h1.Sum(nil)) != string(h2.Sum(nil)) {
+				t.Errorf("%s hasher produced different digests for identical input", name)
+			}
+
+			h3 := hasher.New()
+			h3.Write([]byte("different"))
+			if string(h1.Sum(nil)) == string(h3.Sum(nil)) {
+				t.Errorf("%s hasher produced the same digest for different input", name)
+			}
+		})
+	}
+}
+
+func TestHasherForDefaultsToXxh3(t *testing.T) {
+	hasher, err := hasherFor("")
+	if err != nil {
+		t.Fatalf("hasherFor(\"\"): %v", err)
+	}
+	if _, ok := hasher.(xxh3Hasher); !ok {
+		t.Errorf("hasherFor(\"\") = %T, want xxh3Hasher", hasher)
+	}
+}
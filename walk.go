@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path/filepath"
+)
+
+func fileCheck(paths *[]string, path string, info os.FileInfo, err error) error {
+	if err != nil || info.IsDir() || (info.Mode()&os.ModeSymlink == os.ModeSymlink) {
+		return nil
+	}
+	*paths = append(*paths, path)
+	return nil
+}
+
+// dirWalk walks start collecting file paths, aborting early if ctx is
+// canceled mid-traversal.
+func dirWalk(ctx context.Context, start string) ([]string, error) {
+	var paths []string
+	fileFunc := func(path string, fi os.FileInfo, err error) error {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return fileCheck(&paths, path, fi, err)
+	}
+	walkErr := filepath.Walk(start, fileFunc)
+	if walkErr != nil {
+		return paths, walkErr
+	}
+	return paths, nil
+
+}
+
+func pathsFromSTDIn(ctx context.Context) []string {
+	var paths []string
+	scr := bufio.NewScanner(bufio.NewReader(os.Stdin))
+	for scr.Scan() {
+		if ctx.Err() != nil {
+			break
+		}
+		paths = append(paths, scr.Text())
+	}
+	return paths
+}